@@ -0,0 +1,105 @@
+package v1alpha1
+
+import (
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// PodHeadlessServiceSpec defines the desired state of PodHeadlessService.
+type PodHeadlessServiceSpec struct {
+	// Selector selects the Pods this headless Service targets. Exactly one
+	// of Selector or PodName must be set.
+	// +optional
+	Selector *meta_v1.LabelSelector `json:"selector,omitempty"`
+
+	// PodName selects a single Pod by name instead of Selector. Exactly one
+	// of Selector or PodName must be set.
+	// +optional
+	PodName string `json:"podName,omitempty"`
+
+	// Ports are the named ports to expose on the Service/Endpoints. If
+	// empty, ports are derived from the matched Pod's container ports.
+	// +optional
+	Ports []core_v1.ServicePort `json:"ports,omitempty"`
+
+	// PublishNotReadyAddresses always publishes the matched Pod's IP, even
+	// before it passes its readiness probe. Needed for StatefulSet-style
+	// bootstrapping where peers must discover each other before becoming
+	// ready.
+	// +optional
+	PublishNotReadyAddresses bool `json:"publishNotReadyAddresses,omitempty"`
+
+	// ServiceAnnotations are stamped onto the created Service in addition to
+	// the ones copied from the matched Pod.
+	// +optional
+	ServiceAnnotations map[string]string `json:"serviceAnnotations,omitempty"`
+
+	// ServiceLabels are stamped onto the created Service.
+	// +optional
+	ServiceLabels map[string]string `json:"serviceLabels,omitempty"`
+}
+
+// PodHeadlessServiceConditionType is a valid value for PodHeadlessServiceCondition.Type.
+type PodHeadlessServiceConditionType string
+
+const (
+	// ConditionReady summarizes whether the Service/Endpoints are in sync
+	// with the matched Pod.
+	ConditionReady PodHeadlessServiceConditionType = "Ready"
+	// ConditionServiceCreated reports whether the Service exists.
+	ConditionServiceCreated PodHeadlessServiceConditionType = "ServiceCreated"
+	// ConditionEndpointsSynced reports whether the Endpoints match the
+	// current Pod IP and readiness.
+	ConditionEndpointsSynced PodHeadlessServiceConditionType = "EndpointsSynced"
+)
+
+// PodHeadlessServiceCondition describes the state of a PodHeadlessService at
+// a point in time.
+type PodHeadlessServiceCondition struct {
+	Type               PodHeadlessServiceConditionType `json:"type"`
+	Status             core_v1.ConditionStatus         `json:"status"`
+	LastTransitionTime meta_v1.Time                    `json:"lastTransitionTime,omitempty"`
+	Reason             string                          `json:"reason,omitempty"`
+	Message            string                          `json:"message,omitempty"`
+}
+
+// PodHeadlessServiceStatus defines the observed state of PodHeadlessService.
+type PodHeadlessServiceStatus struct {
+	// ObservedGeneration is the most recent generation reconciled.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// PodIP is the last pod IP reconciled into the Endpoints.
+	// +optional
+	PodIP string `json:"podIP,omitempty"`
+
+	// Conditions holds the latest available observations of the resource's
+	// state.
+	// +optional
+	Conditions []PodHeadlessServiceCondition `json:"conditions,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+
+// PodHeadlessService exposes one or more Pods as a headless Service/Endpoints pair.
+type PodHeadlessService struct {
+	meta_v1.TypeMeta   `json:",inline"`
+	meta_v1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   PodHeadlessServiceSpec   `json:"spec,omitempty"`
+	Status PodHeadlessServiceStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// PodHeadlessServiceList contains a list of PodHeadlessService.
+type PodHeadlessServiceList struct {
+	meta_v1.TypeMeta `json:",inline"`
+	meta_v1.ListMeta `json:"metadata,omitempty"`
+	Items            []PodHeadlessService `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&PodHeadlessService{}, &PodHeadlessServiceList{})
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	core_v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+)
+
+// testEnv is a real kube-apiserver+etcd, started once for the package. It
+// requires KUBEBUILDER_ASSETS (or the binaries in /usr/local/kubebuilder/bin); when unavailable the
+// envtest-backed tests below skip themselves instead of failing the build.
+var testEnv *envtest.Environment
+var testEnvConfigOK bool
+var testClientSet kubernetes.Interface
+
+func TestMain(m *testing.M) {
+	testEnv = &envtest.Environment{}
+
+	cfg, err := testEnv.Start()
+	if err != nil {
+		log.Infof("envtest unavailable, skipping integration tests: %s", err)
+	} else {
+		clientSet, err := kubernetes.NewForConfig(cfg)
+		if err != nil {
+			log.Infof("building clientset from envtest config: %s", err)
+		} else {
+			testEnvConfigOK = true
+			testClientSet = clientSet
+		}
+	}
+
+	code := m.Run()
+
+	if testEnvConfigOK {
+		if err := testEnv.Stop(); err != nil {
+			log.Infof("stopping envtest: %s", err)
+		}
+	}
+
+	os.Exit(code)
+}
+
+func requireEnvtest(t *testing.T) {
+	t.Helper()
+	if !testEnvConfigOK {
+		t.Skip("envtest binaries not available (set KUBEBUILDER_ASSETS)")
+	}
+}
+
+func createNamespace(t *testing.T, name string) {
+	t.Helper()
+	_, err := testClientSet.CoreV1().Namespaces().Create(&core_v1.Namespace{
+		ObjectMeta: meta_v1.ObjectMeta{Name: name},
+	})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		t.Fatalf("creating namespace %s: %s", name, err)
+	}
+}
+
+func createPod(t *testing.T, namespace, name string, annotated bool) *core_v1.Pod {
+	t.Helper()
+	pod := &core_v1.Pod{
+		ObjectMeta: meta_v1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec: core_v1.PodSpec{
+			Containers: []core_v1.Container{{
+				Name:  "app",
+				Image: "busybox",
+				Ports: []core_v1.ContainerPort{{ContainerPort: 8080}},
+			}},
+		},
+	}
+
+	if annotated {
+		pod.Annotations = map[string]string{testAnnotation: "true"}
+	}
+
+	created, err := testClientSet.CoreV1().Pods(namespace).Create(pod)
+	if err != nil {
+		t.Fatalf("creating pod %s/%s: %s", namespace, name, err)
+	}
+
+	return created
+}
+
+func setPodReadyWithIP(t *testing.T, pod *core_v1.Pod, ip string) *core_v1.Pod {
+	t.Helper()
+	pod.Status.PodIP = ip
+	pod.Status.Conditions = []core_v1.PodCondition{{Type: core_v1.PodReady, Status: core_v1.ConditionTrue}}
+
+	updated, err := testClientSet.CoreV1().Pods(pod.GetNamespace()).UpdateStatus(pod)
+	if err != nil {
+		t.Fatalf("updating pod status for %s/%s: %s", pod.GetNamespace(), pod.GetName(), err)
+	}
+
+	return updated
+}
+
+func startLegacyController(t *testing.T) context.CancelFunc {
+	t.Helper()
+
+	r := &RunCommand{Annotation: testAnnotation, Workers: 1, ResyncPeriod: time.Second, clientSet: testClientSet}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	go r.runController(ctx)
+
+	return cancel
+}
+
+func waitForService(t *testing.T, namespace, name string, present bool) {
+	t.Helper()
+
+	err := wait.Poll(50*time.Millisecond, 5*time.Second, func() (bool, error) {
+		_, err := testClientSet.CoreV1().Services(namespace).Get(name, meta_v1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return !present, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		return present, nil
+	})
+	if err != nil {
+		t.Fatalf("waiting for service %s/%s present=%v: %s", namespace, name, present, err)
+	}
+}
+
+func waitForEndpointIP(t *testing.T, namespace, name, ip string) {
+	t.Helper()
+
+	err := wait.Poll(50*time.Millisecond, 5*time.Second, func() (bool, error) {
+		endpoints, err := testClientSet.CoreV1().Endpoints(namespace).Get(name, meta_v1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+
+		if len(endpoints.Subsets) == 0 || len(endpoints.Subsets[0].Addresses) == 0 {
+			return false, nil
+		}
+
+		return endpoints.Subsets[0].Addresses[0].IP == ip, nil
+	})
+	if err != nil {
+		t.Fatalf("waiting for endpoints %s/%s to report IP %s: %s", namespace, name, ip, err)
+	}
+}
+
+func TestLegacyControllerSkipsUnannotatedPods(t *testing.T) {
+	requireEnvtest(t)
+	createNamespace(t, "no-annotation")
+	cancel := startLegacyController(t)
+	defer cancel()
+
+	pod := createPod(t, "no-annotation", "web-0", false)
+	setPodReadyWithIP(t, pod, "10.0.0.1")
+
+	time.Sleep(200 * time.Millisecond)
+	waitForService(t, "no-annotation", "web-0", false)
+}
+
+func TestLegacyControllerCreatesServiceOnceIPIsAssigned(t *testing.T) {
+	requireEnvtest(t)
+	createNamespace(t, "annotated")
+	cancel := startLegacyController(t)
+	defer cancel()
+
+	pod := createPod(t, "annotated", "web-0", true)
+
+	time.Sleep(200 * time.Millisecond)
+	waitForService(t, "annotated", "web-0", false)
+
+	setPodReadyWithIP(t, pod, "10.0.1.1")
+
+	waitForService(t, "annotated", "web-0", true)
+	waitForEndpointIP(t, "annotated", "web-0", "10.0.1.1")
+}
+
+func TestLegacyControllerDeletesServiceWhenAnnotationRemoved(t *testing.T) {
+	requireEnvtest(t)
+	createNamespace(t, "annotation-removed")
+	cancel := startLegacyController(t)
+	defer cancel()
+
+	pod := createPod(t, "annotation-removed", "web-0", true)
+	pod = setPodReadyWithIP(t, pod, "10.0.3.1")
+	waitForService(t, "annotation-removed", "web-0", true)
+
+	delete(pod.Annotations, testAnnotation)
+	updated, err := testClientSet.CoreV1().Pods(pod.GetNamespace()).Update(pod)
+	if err != nil {
+		t.Fatalf("removing annotation from pod: %s", err)
+	}
+	setPodReadyWithIP(t, updated, "10.0.3.1")
+
+	waitForService(t, "annotation-removed", "web-0", false)
+}
+
+func TestLegacyControllerUpdatesEndpointsInPlaceOnIPChange(t *testing.T) {
+	requireEnvtest(t)
+	createNamespace(t, "ip-change")
+	cancel := startLegacyController(t)
+	defer cancel()
+
+	pod := createPod(t, "ip-change", "web-0", true)
+	pod = setPodReadyWithIP(t, pod, "10.0.2.1")
+	waitForEndpointIP(t, "ip-change", "web-0", "10.0.2.1")
+
+	serviceBefore, err := testClientSet.CoreV1().Services("ip-change").Get("web-0", meta_v1.GetOptions{})
+	if err != nil {
+		t.Fatalf("getting service: %s", err)
+	}
+
+	setPodReadyWithIP(t, pod, "10.0.2.2")
+	waitForEndpointIP(t, "ip-change", "web-0", "10.0.2.2")
+
+	serviceAfter, err := testClientSet.CoreV1().Services("ip-change").Get("web-0", meta_v1.GetOptions{})
+	if err != nil {
+		t.Fatalf("getting service: %s", err)
+	}
+
+	if serviceBefore.GetUID() != serviceAfter.GetUID() {
+		t.Fatal("expected the service to be updated in place, not recreated, on an IP change")
+	}
+}
+
+func TestLegacyControllerSameNamedPodsAcrossNamespacesDoNotCollide(t *testing.T) {
+	requireEnvtest(t)
+	createNamespace(t, "team-a")
+	createNamespace(t, "team-b")
+	cancel := startLegacyController(t)
+	defer cancel()
+
+	podA := createPod(t, "team-a", "web-0", true)
+	podB := createPod(t, "team-b", "web-0", true)
+
+	setPodReadyWithIP(t, podA, "10.10.0.1")
+	setPodReadyWithIP(t, podB, "10.10.0.2")
+
+	waitForEndpointIP(t, "team-a", "web-0", "10.10.0.1")
+	waitForEndpointIP(t, "team-b", "web-0", "10.10.0.2")
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/apex/log"
+	core_v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// legacyController drives Service/Endpoints off Pod informer events through a
+// rate-limited workqueue, modeled on k8s.io/kubernetes/pkg/controller/endpoint:
+// event handlers only enqueue a namespace/name key, and syncHandler is the
+// single idempotent entry point workers call to reconcile it. A transient API
+// error is retried with backoff via AddRateLimited instead of being dropped on
+// the floor, and running more than one replica becomes safe once paired with
+// leader election.
+type legacyController struct {
+	run      *RunCommand
+	informer cache.SharedIndexInformer
+	queue    workqueue.RateLimitingInterface
+}
+
+func newLegacyController(run *RunCommand, informer cache.SharedIndexInformer) *legacyController {
+	c := &legacyController{
+		run:      run,
+		informer: informer,
+		queue:    workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "pods"),
+	}
+
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.enqueue,
+		UpdateFunc: func(oldObj, newObj interface{}) { c.enqueue(newObj) },
+	})
+
+	return c
+}
+
+func (c *legacyController) enqueue(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		runtime.HandleError(fmt.Errorf("computing key for %v: %s", obj, err))
+		return
+	}
+
+	c.queue.Add(key)
+}
+
+// runWorkers starts workers goroutines pulling keys off the queue, and blocks
+// until ctx is cancelled.
+func (c *legacyController) runWorkers(ctx context.Context, workers int) {
+	defer c.queue.ShutDown()
+
+	for i := 0; i < workers; i++ {
+		go wait.Until(c.worker, time.Second, ctx.Done())
+	}
+
+	<-ctx.Done()
+}
+
+func (c *legacyController) worker() {
+	for c.processNextItem() {
+	}
+}
+
+func (c *legacyController) processNextItem() bool {
+	key, shutdown := c.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer c.queue.Done(key)
+
+	if err := c.syncHandler(key.(string)); err != nil {
+		runtime.HandleError(fmt.Errorf("syncing %q: %s", key, err))
+		c.queue.AddRateLimited(key)
+		return true
+	}
+
+	c.queue.Forget(key)
+	return true
+}
+
+// syncHandler is idempotent: given a pod's namespace/name key, it brings the
+// pod's Service/Endpoints in line with its current annotation, IP and
+// readiness. It is safe to call repeatedly for the same key, and with the
+// same pod state, from retries or resyncs.
+func (c *legacyController) syncHandler(key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+
+	obj, exists, err := c.informer.GetIndexer().GetByKey(key)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		log.Infof("%s/%s no longer exists, relying on OwnerReferences for cleanup", namespace, name)
+		return nil
+	}
+
+	return c.run.updateService(obj.(*core_v1.Pod))
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"os/signal"
 	"syscall"
@@ -11,12 +12,20 @@ import (
 
 	gocli "gopkg.in/src-d/go-cli.v0"
 	core_v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/uuid"
 	coreinformers "k8s.io/client-go/informers/core/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	"github.com/src-d/k8s-pod-headless-service-operator/controllers"
+	"github.com/src-d/k8s-pod-headless-service-operator/pkg/headlessservice"
 )
 
 func init() {
@@ -25,75 +34,188 @@ func init() {
 
 type RunCommand struct {
 	gocli.PlainCommand `name:"run" short-description:"run a watcher for " long-description:"Run an in-cluster watcher for PVs and create the needed paths if needed"`
-	KubernetesContext  string `long:"context" env:"KUBERNETES_CONTEXT" description:"If set the program will load the kubernetes configuration from a kubeconfig file for the given context"`
-	Namespace          string `long:"namespace" env:"NAMESPACE" default:"" description:"Namespace to watch, defaults to all"`
-	Annotation         string `long:"pod-annotation" env:"POD_ANNOTATION" default:"srcd.host/create-headless-service" description:"annotation that needs to be set to 'true' for the service to be created"`
-	clientSet          *kubernetes.Clientset
+	KubernetesContext  string        `long:"context" env:"KUBERNETES_CONTEXT" description:"If set the program will load the kubernetes configuration from a kubeconfig file for the given context"`
+	Namespace          string        `long:"namespace" env:"NAMESPACE" default:"" description:"Namespace to watch, defaults to all"`
+	Annotation         string        `long:"pod-annotation" env:"POD_ANNOTATION" default:"srcd.host/create-headless-service" description:"annotation that needs to be set to 'true' for the service to be created"`
+	LegacyAnnotations  bool          `long:"legacy-annotations" env:"LEGACY_ANNOTATIONS" description:"watch Pods directly and drive Service/Endpoints from the pod-annotation flag instead of reconciling PodHeadlessService resources; kept for migration"`
+	Workers            int           `long:"workers" env:"WORKERS" default:"2" description:"number of workers processing the legacy-annotations workqueue concurrently"`
+	ResyncPeriod       time.Duration `long:"resync-period" env:"RESYNC_PERIOD" default:"1m" description:"how often the legacy-annotations pod informer resyncs its cache"`
+	LeaderElect        bool          `long:"leader-elect" env:"LEADER_ELECT" description:"use leader election when running legacy-annotations, so only one replica reconciles at a time"`
+	clientSet          kubernetes.Interface
 }
 
 func (r *RunCommand) ExecuteContext(ctx context.Context, args []string) error {
+	if r.LegacyAnnotations {
+		return r.runLegacy(ctx)
+	}
+
+	return r.runReconciler(ctx)
+}
+
+// runReconciler starts a controller-runtime Manager running the
+// PodHeadlessServiceReconciler, which watches PodHeadlessService resources
+// plus the Pods/Services/Endpoints they own.
+func (r *RunCommand) runReconciler(ctx context.Context) error {
+	config, err := r.restConfig()
+	if err != nil {
+		return err
+	}
+
+	mgr, err := ctrl.NewManager(config, ctrl.Options{Namespace: r.Namespace})
+	if err != nil {
+		return err
+	}
+
+	if err := controllers.SetupScheme(mgr.GetScheme()); err != nil {
+		return err
+	}
+
+	if err := (&controllers.PodHeadlessServiceReconciler{
+		Client: mgr.GetClient(),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		return err
+	}
+
+	log.Info("Reconciling PodHeadlessService resources")
+
+	return mgr.Start(ctx.Done())
+}
+
+// runLegacy is the pre-CRD behavior: it watches Pods directly and creates a
+// Service/Endpoints pair for any pod carrying r.Annotation. It only exists to
+// give operators time to migrate existing pods onto PodHeadlessService
+// resources.
+func (r *RunCommand) runLegacy(ctx context.Context) error {
 	var err error
 	r.clientSet, err = r.getClientSet()
 	if err != nil {
 		return err
 	}
-	podInformer := coreinformers.NewPodInformer(r.clientSet, r.Namespace, time.Minute, cache.Indexers{})
 
-	podInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
-		AddFunc: func(obj interface{}) {
-			err := r.setUpService(obj.(*core_v1.Pod))
-			if err != nil {
-				log.Infof("Error setting up service: %s", err)
-			}
-		},
-		UpdateFunc: func(oldObj interface{}, newObj interface{}) {
-			err := r.updateService(newObj.(*core_v1.Pod))
-			if err != nil {
-				log.Infof("Error updating service: %s", err)
-			}
-		},
-		DeleteFunc: func(obj interface{}) {
-			err := r.deleteService(obj.(*core_v1.Pod))
-			if err != nil {
-				log.Infof("Error deleting service: %s", err)
-			}
-		},
-	})
+	if err := r.reconcileOrphanServices(); err != nil {
+		return err
+	}
 
-	stop := make(chan struct{})
-	defer close(stop)
-	go podInformer.Run(stop)
+	ctx, cancel := signalCancel(ctx)
+	defer cancel()
 
-	log.Info("Watching pods")
+	if !r.LeaderElect {
+		r.runController(ctx)
+		return nil
+	}
 
-	sig := make(chan os.Signal, 1)
-	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	return r.runWithLeaderElection(ctx)
+}
+
+// runController watches Pods and reconciles their Service/Endpoints through a
+// rate-limited workqueue, blocking until ctx is cancelled.
+func (r *RunCommand) runController(ctx context.Context) {
+	informer := coreinformers.NewPodInformer(r.clientSet, r.Namespace, r.ResyncPeriod, cache.Indexers{})
+	controller := newLegacyController(r, informer)
+
+	go informer.Run(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+		log.Error("timed out waiting for the pod cache to sync")
+		return
+	}
+
+	log.Infof("Watching pods with %d workers (legacy annotation mode)", r.Workers)
+	controller.runWorkers(ctx, r.Workers)
+}
 
-	<-sig
-	stop <- struct{}{}
+// runWithLeaderElection wraps runController in a leader election so that,
+// when several replicas of the operator are running, only the elected leader
+// reconciles Pods at a time. The lease lives in r.Namespace, falling back to
+// "default" when no namespace was given to watch all of them.
+func (r *RunCommand) runWithLeaderElection(ctx context.Context) error {
+	namespace := r.Namespace
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	id := fmt.Sprintf("pod-headless-service-operator-%s", uuid.NewUUID())
+
+	lock, err := resourcelock.New(
+		resourcelock.LeasesResourceLock,
+		namespace,
+		"pod-headless-service-operator",
+		r.clientSet.CoreV1(),
+		r.clientSet.CoordinationV1(),
+		resourcelock.ResourceLockConfig{Identity: id},
+	)
+	if err != nil {
+		return err
+	}
+
+	leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   15 * time.Second,
+		RenewDeadline:   10 * time.Second,
+		RetryPeriod:     2 * time.Second,
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				log.Infof("%s started leading, reconciling pods", id)
+				r.runController(ctx)
+			},
+			OnStoppedLeading: func() {
+				log.Infof("%s stopped leading", id)
+			},
+			OnNewLeader: func(identity string) {
+				if identity != id {
+					log.Infof("%s is leading", identity)
+				}
+			},
+		},
+	})
 
 	return nil
 }
 
-func (r *RunCommand) getClientSet() (*kubernetes.Clientset, error) {
-	if r.clientSet != nil {
-		return r.clientSet, nil
+// signalCancel returns a context that is cancelled either when parent is, or
+// when the process receives SIGINT/SIGTERM, and a cancel func releasing the
+// signal.Notify registration.
+func signalCancel(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
 
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case <-sig:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, func() {
+		signal.Stop(sig)
+		cancel()
 	}
+}
 
-	var config *rest.Config
-	var err error
+func (r *RunCommand) restConfig() (*rest.Config, error) {
 	if r.KubernetesContext != "" {
-		config, err = clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
 			clientcmd.NewDefaultClientConfigLoadingRules(),
 			&clientcmd.ConfigOverrides{
 				CurrentContext: r.KubernetesContext,
 			},
 		).ClientConfig()
-	} else {
-		config, err = rest.InClusterConfig()
 	}
 
+	return rest.InClusterConfig()
+}
+
+func (r *RunCommand) getClientSet() (kubernetes.Interface, error) {
+	if r.clientSet != nil {
+		return r.clientSet, nil
+
+	}
+
+	config, err := r.restConfig()
 	if err != nil {
 		return nil, err
 	}
@@ -114,6 +236,11 @@ func (r *RunCommand) hasExistingService(pod *core_v1.Pod) bool {
 func (r *RunCommand) updateService(pod *core_v1.Pod) error {
 	log.Infof("Updating pod %s", pod.ObjectMeta.Name)
 	if pod.Annotations[r.Annotation] != "true" {
+		if r.hasExistingService(pod) {
+			log.Infof("%s no longer has annotation set, deleting its service", pod.ObjectMeta.Name)
+			return r.deleteService(pod)
+		}
+
 		log.Infof("%s doesn't have annotation set, skipping", pod.ObjectMeta.Name)
 		return nil
 	}
@@ -138,23 +265,20 @@ func (r *RunCommand) updateService(pod *core_v1.Pod) error {
 		return err
 	}
 
-	if len(endpoint.Subsets) == 0 || len(endpoint.Subsets[0].Addresses) == 0 || endpoint.Subsets[0].Addresses[0].IP != pod.Status.PodIP {
-		log.Infof("%s has a new Pod IP, updating it", pod.ObjectMeta.Name)
-		// update the pod IP
+	if headlessservice.EndpointNeedsUpdate(endpoint, pod) {
+		log.Infof("%s has a new Pod IP or readiness state, updating it", pod.ObjectMeta.Name)
+
+		ports, err := headlessservice.ServicePorts(pod)
+		if err != nil {
+			return err
+		}
+
 		_, err = client.CoreV1().Endpoints(pod.GetNamespace()).Update(&core_v1.Endpoints{
 			ObjectMeta: meta_v1.ObjectMeta{
 				Name:        pod.GetObjectMeta().GetName(),
 				Annotations: pod.GetAnnotations(),
 			},
-			Subsets: []core_v1.EndpointSubset{
-				core_v1.EndpointSubset{
-					Addresses: []core_v1.EndpointAddress{
-						core_v1.EndpointAddress{
-							IP: pod.Status.PodIP,
-						},
-					},
-				},
-			},
+			Subsets: []core_v1.EndpointSubset{headlessservice.EndpointSubset(pod, ports)},
 		})
 
 		if err != nil {
@@ -165,6 +289,27 @@ func (r *RunCommand) updateService(pod *core_v1.Pod) error {
 	return nil
 }
 
+// deleteService removes the Service/Endpoints for pod. It exists for the
+// still-running-pod case, where the annotation was removed: OwnerReferences
+// (chunk0-4) only clean up once the pod itself is deleted, so they don't
+// catch this one.
+func (r *RunCommand) deleteService(pod *core_v1.Pod) error {
+	client, err := r.getClientSet()
+	if err != nil {
+		return err
+	}
+
+	if err := client.CoreV1().Services(pod.GetNamespace()).Delete(pod.GetName(), &meta_v1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+
+	if err := client.CoreV1().Endpoints(pod.GetNamespace()).Delete(pod.GetName(), &meta_v1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+
+	return nil
+}
+
 func (r *RunCommand) setUpService(pod *core_v1.Pod) error {
 	log.Infof("Setting up pod %s", pod.ObjectMeta.Name)
 	if pod.Annotations[r.Annotation] != "true" {
@@ -187,13 +332,25 @@ func (r *RunCommand) setUpService(pod *core_v1.Pod) error {
 		return err
 	}
 
+	ports, err := headlessservice.ServicePorts(pod)
+	if err != nil {
+		return err
+	}
+
+	ownerRef := headlessservice.PodOwnerReference(pod)
+	managedByLabels := map[string]string{controllers.ManagedByLabel: controllers.ManagedByValue}
+
 	_, err = client.CoreV1().Services(pod.GetNamespace()).Create(&core_v1.Service{
 		ObjectMeta: meta_v1.ObjectMeta{
-			Name:        pod.GetObjectMeta().GetName(),
-			Annotations: pod.GetAnnotations(),
+			Name:            pod.GetObjectMeta().GetName(),
+			Annotations:     headlessservice.ServiceAnnotations(pod),
+			Labels:          managedByLabels,
+			OwnerReferences: []meta_v1.OwnerReference{ownerRef},
 		},
 		Spec: core_v1.ServiceSpec{
-			ClusterIP: "None", // headless service
+			ClusterIP:                "None", // headless service
+			Ports:                    ports,
+			PublishNotReadyAddresses: headlessservice.PublishNotReadyAddresses(pod),
 		},
 	})
 
@@ -204,44 +361,55 @@ func (r *RunCommand) setUpService(pod *core_v1.Pod) error {
 	// endpoints is needed as a Service selector will select all replicas in a replicaset
 	_, err = client.CoreV1().Endpoints(pod.GetNamespace()).Create(&core_v1.Endpoints{
 		ObjectMeta: meta_v1.ObjectMeta{
-			Name:        pod.GetObjectMeta().GetName(),
-			Annotations: pod.GetAnnotations(),
-		},
-		Subsets: []core_v1.EndpointSubset{
-			core_v1.EndpointSubset{
-				Addresses: []core_v1.EndpointAddress{
-					core_v1.EndpointAddress{
-						IP: pod.Status.PodIP,
-					},
-				},
-			},
+			Name:            pod.GetObjectMeta().GetName(),
+			Annotations:     pod.GetAnnotations(),
+			Labels:          managedByLabels,
+			OwnerReferences: []meta_v1.OwnerReference{ownerRef},
 		},
+		Subsets: []core_v1.EndpointSubset{headlessservice.EndpointSubset(pod, ports)},
 	})
 
 	return err
 }
 
-func (r *RunCommand) deleteService(pod *core_v1.Pod) error {
-	log.Infof("Deleting service for pod %s", pod.ObjectMeta.Name)
-	if pod.Annotations[r.Annotation] != "true" {
-		log.Infof("%s doesn't have annotation set, skipping", pod.ObjectMeta.Name)
-		return nil
-	}
-
-	if !r.hasExistingService(pod) {
-		log.Infof("%s does not have a service, skipping", pod.ObjectMeta.Name)
-		return r.updateService(pod)
-	}
-
+// reconcileOrphanServices runs once at startup. Service/Endpoints created by
+// setUpService carry an OwnerReference to their Pod, so the Kubernetes
+// garbage collector deletes them once the Pod is gone; this only catches
+// Services that were created before OwnerReferences were set, or whose
+// deletion was missed while the operator wasn't running.
+func (r *RunCommand) reconcileOrphanServices() error {
 	client, err := r.getClientSet()
 	if err != nil {
 		return err
 	}
 
-	err = client.CoreV1().Services(pod.GetNamespace()).Delete(pod.GetName(), &meta_v1.DeleteOptions{})
+	services, err := client.CoreV1().Services(r.Namespace).List(meta_v1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s", controllers.ManagedByLabel, controllers.ManagedByValue),
+	})
 	if err != nil {
 		return err
 	}
 
-	return client.CoreV1().Endpoints(pod.GetNamespace()).Delete(pod.GetName(), &meta_v1.DeleteOptions{})
+	for _, svc := range services.Items {
+		_, err := client.CoreV1().Pods(svc.GetNamespace()).Get(svc.GetName(), meta_v1.GetOptions{})
+		if err == nil {
+			continue
+		}
+
+		if !apierrors.IsNotFound(err) {
+			return err
+		}
+
+		log.Infof("%s/%s has no owning pod left, deleting orphaned service", svc.GetNamespace(), svc.GetName())
+
+		if err := client.CoreV1().Services(svc.GetNamespace()).Delete(svc.GetName(), &meta_v1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+
+		if err := client.CoreV1().Endpoints(svc.GetNamespace()).Delete(svc.GetName(), &meta_v1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	return nil
 }
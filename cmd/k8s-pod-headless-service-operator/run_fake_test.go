@@ -0,0 +1,181 @@
+package main
+
+import (
+	"testing"
+
+	core_v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const testAnnotation = "srcd.host/create-headless-service"
+
+func readyPod(namespace, name, ip string, annotated bool) *core_v1.Pod {
+	pod := &core_v1.Pod{
+		ObjectMeta: meta_v1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec: core_v1.PodSpec{
+			Containers: []core_v1.Container{{
+				Name:  "app",
+				Ports: []core_v1.ContainerPort{{ContainerPort: 8080}},
+			}},
+		},
+		Status: core_v1.PodStatus{
+			PodIP:      ip,
+			Conditions: []core_v1.PodCondition{{Type: core_v1.PodReady, Status: core_v1.ConditionTrue}},
+		},
+	}
+
+	if annotated {
+		pod.Annotations = map[string]string{testAnnotation: "true"}
+	}
+
+	return pod
+}
+
+func TestSetUpServiceSkipsPodsWithoutAnnotation(t *testing.T) {
+	pod := readyPod("default", "web-0", "10.0.0.1", false)
+	r := &RunCommand{Annotation: testAnnotation, clientSet: fake.NewSimpleClientset(pod)}
+
+	if err := r.setUpService(pod); err != nil {
+		t.Fatalf("setUpService: %s", err)
+	}
+
+	if r.hasExistingService(pod) {
+		t.Fatal("expected no service to be created for an unannotated pod")
+	}
+}
+
+func TestSetUpServiceSkipsPodsWithoutIP(t *testing.T) {
+	pod := readyPod("default", "web-0", "", true)
+	r := &RunCommand{Annotation: testAnnotation, clientSet: fake.NewSimpleClientset(pod)}
+
+	if err := r.setUpService(pod); err != nil {
+		t.Fatalf("setUpService: %s", err)
+	}
+
+	if r.hasExistingService(pod) {
+		t.Fatal("expected no service to be created before the pod has an IP")
+	}
+}
+
+func TestSetUpServiceCreatesServiceAndEndpoints(t *testing.T) {
+	pod := readyPod("default", "web-0", "10.0.0.1", true)
+	clientSet := fake.NewSimpleClientset(pod)
+	r := &RunCommand{Annotation: testAnnotation, clientSet: clientSet}
+
+	if err := r.setUpService(pod); err != nil {
+		t.Fatalf("setUpService: %s", err)
+	}
+
+	if !r.hasExistingService(pod) {
+		t.Fatal("expected a service to be created")
+	}
+
+	endpoints, err := clientSet.CoreV1().Endpoints(pod.GetNamespace()).Get(pod.GetName(), meta_v1.GetOptions{})
+	if err != nil {
+		t.Fatalf("getting endpoints: %s", err)
+	}
+
+	if len(endpoints.Subsets) != 1 || len(endpoints.Subsets[0].Addresses) != 1 {
+		t.Fatalf("expected one ready address in endpoints, got %+v", endpoints.Subsets)
+	}
+
+	if endpoints.Subsets[0].Addresses[0].IP != pod.Status.PodIP {
+		t.Fatalf("expected endpoint IP %q, got %q", pod.Status.PodIP, endpoints.Subsets[0].Addresses[0].IP)
+	}
+}
+
+func TestUpdateServiceUpdatesEndpointsInPlaceOnIPChange(t *testing.T) {
+	pod := readyPod("default", "web-0", "10.0.0.1", true)
+	clientSet := fake.NewSimpleClientset(pod)
+	r := &RunCommand{Annotation: testAnnotation, clientSet: clientSet}
+
+	if err := r.setUpService(pod); err != nil {
+		t.Fatalf("setUpService: %s", err)
+	}
+
+	serviceBefore, err := clientSet.CoreV1().Services(pod.GetNamespace()).Get(pod.GetName(), meta_v1.GetOptions{})
+	if err != nil {
+		t.Fatalf("getting service: %s", err)
+	}
+
+	pod.Status.PodIP = "10.0.0.2"
+	if err := r.updateService(pod); err != nil {
+		t.Fatalf("updateService: %s", err)
+	}
+
+	endpoints, err := clientSet.CoreV1().Endpoints(pod.GetNamespace()).Get(pod.GetName(), meta_v1.GetOptions{})
+	if err != nil {
+		t.Fatalf("getting endpoints: %s", err)
+	}
+
+	if endpoints.Subsets[0].Addresses[0].IP != "10.0.0.2" {
+		t.Fatalf("expected endpoint IP to follow the pod to 10.0.0.2, got %q", endpoints.Subsets[0].Addresses[0].IP)
+	}
+
+	serviceAfter, err := clientSet.CoreV1().Services(pod.GetNamespace()).Get(pod.GetName(), meta_v1.GetOptions{})
+	if err != nil {
+		t.Fatalf("getting service: %s", err)
+	}
+
+	if serviceBefore.GetResourceVersion() != serviceAfter.GetResourceVersion() {
+		t.Fatal("expected the service to be left untouched on an IP change, not recreated")
+	}
+}
+
+func TestUpdateServiceDeletesServiceWhenAnnotationRemoved(t *testing.T) {
+	pod := readyPod("default", "web-0", "10.0.0.1", true)
+	clientSet := fake.NewSimpleClientset(pod)
+	r := &RunCommand{Annotation: testAnnotation, clientSet: clientSet}
+
+	if err := r.setUpService(pod); err != nil {
+		t.Fatalf("setUpService: %s", err)
+	}
+	if !r.hasExistingService(pod) {
+		t.Fatal("expected a service to be created")
+	}
+
+	delete(pod.Annotations, testAnnotation)
+	if err := r.updateService(pod); err != nil {
+		t.Fatalf("updateService: %s", err)
+	}
+
+	if r.hasExistingService(pod) {
+		t.Fatal("expected the service to be deleted once the annotation was removed")
+	}
+
+	if _, err := clientSet.CoreV1().Endpoints(pod.GetNamespace()).Get(pod.GetName(), meta_v1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Fatalf("expected endpoints to be deleted too, got err=%v", err)
+	}
+}
+
+func TestSameNamedPodsAcrossNamespacesDoNotCollide(t *testing.T) {
+	podA := readyPod("team-a", "web-0", "10.0.0.1", true)
+	podB := readyPod("team-b", "web-0", "10.0.0.2", true)
+	clientSet := fake.NewSimpleClientset(podA, podB)
+	r := &RunCommand{Annotation: testAnnotation, clientSet: clientSet}
+
+	if err := r.setUpService(podA); err != nil {
+		t.Fatalf("setUpService(podA): %s", err)
+	}
+	if err := r.setUpService(podB); err != nil {
+		t.Fatalf("setUpService(podB): %s", err)
+	}
+
+	endpointsA, err := clientSet.CoreV1().Endpoints(podA.GetNamespace()).Get(podA.GetName(), meta_v1.GetOptions{})
+	if err != nil {
+		t.Fatalf("getting endpoints for team-a: %s", err)
+	}
+	endpointsB, err := clientSet.CoreV1().Endpoints(podB.GetNamespace()).Get(podB.GetName(), meta_v1.GetOptions{})
+	if err != nil {
+		t.Fatalf("getting endpoints for team-b: %s", err)
+	}
+
+	if endpointsA.Subsets[0].Addresses[0].IP != podA.Status.PodIP {
+		t.Fatalf("team-a endpoints got the wrong IP %q", endpointsA.Subsets[0].Addresses[0].IP)
+	}
+	if endpointsB.Subsets[0].Addresses[0].IP != podB.Status.PodIP {
+		t.Fatalf("team-b endpoints got the wrong IP %q", endpointsB.Subsets[0].Addresses[0].IP)
+	}
+}
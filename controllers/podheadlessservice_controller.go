@@ -0,0 +1,317 @@
+// Package controllers contains the controller-runtime reconcilers for this
+// operator's CRDs.
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/apex/log"
+	core_v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	srcdv1alpha1 "github.com/src-d/k8s-pod-headless-service-operator/api/v1alpha1"
+	"github.com/src-d/k8s-pod-headless-service-operator/pkg/headlessservice"
+)
+
+// ManagedByLabel marks every Service/Endpoints this controller creates, so
+// the startup reconciliation pass can find and garbage-collect orphans.
+const ManagedByLabel = "srcd.host/managed-by"
+
+// ManagedByValue is the value ManagedByLabel is set to.
+const ManagedByValue = "pod-headless-service-operator"
+
+// PodHeadlessServiceReconciler reconciles a PodHeadlessService object.
+type PodHeadlessServiceReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+// SetupScheme registers this operator's API types against scheme, in
+// addition to the client-go built-ins controller-runtime already knows.
+func SetupScheme(scheme *runtime.Scheme) error {
+	return srcdv1alpha1.AddToScheme(scheme)
+}
+
+// SetupWithManager wires the reconciler into mgr, watching PodHeadlessService
+// resources plus the Pods/Services/Endpoints it needs to map back to them.
+func (r *PodHeadlessServiceReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	c, err := ctrl.NewControllerManagedBy(mgr).
+		For(&srcdv1alpha1.PodHeadlessService{}).
+		Owns(&core_v1.Service{}).
+		Owns(&core_v1.Endpoints{}).
+		Build(r)
+	if err != nil {
+		return err
+	}
+
+	return c.Watch(
+		&source.Kind{Type: &core_v1.Pod{}},
+		&handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(r.mapPodToRequests)},
+	)
+}
+
+// mapPodToRequests maps a watched Pod back to the PodHeadlessService
+// resources in its namespace that could select it, so pod IP/readiness
+// changes trigger a reconcile even though Pods aren't Owned by the CR.
+func (r *PodHeadlessServiceReconciler) mapPodToRequests(o handler.MapObject) []ctrl.Request {
+	pod, ok := o.Object.(*core_v1.Pod)
+	if !ok {
+		return nil
+	}
+
+	var list srcdv1alpha1.PodHeadlessServiceList
+	if err := r.List(context.Background(), &list, client.InNamespace(pod.GetNamespace())); err != nil {
+		log.Errorf("listing PodHeadlessService while mapping pod %s/%s: %s", pod.GetNamespace(), pod.GetName(), err)
+		return nil
+	}
+
+	var requests []ctrl.Request
+	for i := range list.Items {
+		phs := &list.Items[i]
+		matches, err := matchesPod(phs, pod)
+		if err != nil {
+			log.Errorf("matching PodHeadlessService %s/%s against pod %s: %s", phs.GetNamespace(), phs.GetName(), pod.GetName(), err)
+			continue
+		}
+
+		if matches {
+			requests = append(requests, ctrl.Request{
+				NamespacedName: types.NamespacedName{Namespace: phs.GetNamespace(), Name: phs.GetName()},
+			})
+		}
+	}
+
+	return requests
+}
+
+// +kubebuilder:rbac:groups=srcd.host,resources=podheadlessservices,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=srcd.host,resources=podheadlessservices/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=services;endpoints,verbs=get;list;watch;create;update;patch;delete
+
+// Reconcile brings the Service/Endpoints for req's PodHeadlessService in line
+// with its matched Pod.
+func (r *PodHeadlessServiceReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx := context.Background()
+
+	var phs srcdv1alpha1.PodHeadlessService
+	if err := r.Get(ctx, req.NamespacedName, &phs); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	pod, err := r.matchedPod(ctx, &phs)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if pod == nil || pod.Status.PodIP == "" {
+		if err := r.clearEndpoints(ctx, &phs); err != nil {
+			return ctrl.Result{}, err
+		}
+
+		return ctrl.Result{}, r.updateStatus(ctx, &phs, nil)
+	}
+
+	ports := phs.Spec.Ports
+	if len(ports) == 0 {
+		if ports, err = headlessservice.ServicePorts(pod); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	if err := r.reconcileService(ctx, &phs, pod, ports); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if err := r.reconcileEndpoints(ctx, &phs, pod, ports); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{}, r.updateStatus(ctx, &phs, pod)
+}
+
+// matchedPod resolves phs.Spec.PodName or phs.Spec.Selector to the Pod it
+// targets. A selector may match more than one Pod; like the legacy
+// annotation mode, only one IP can back the headless Service, so the first
+// match (by name) is used.
+func (r *PodHeadlessServiceReconciler) matchedPod(ctx context.Context, phs *srcdv1alpha1.PodHeadlessService) (*core_v1.Pod, error) {
+	if phs.Spec.PodName != "" {
+		var pod core_v1.Pod
+		key := types.NamespacedName{Namespace: phs.GetNamespace(), Name: phs.Spec.PodName}
+		if err := r.Get(ctx, key, &pod); err != nil {
+			if apierrors.IsNotFound(err) {
+				return nil, nil
+			}
+			return nil, err
+		}
+
+		return &pod, nil
+	}
+
+	if phs.Spec.Selector == nil {
+		return nil, fmt.Errorf("PodHeadlessService %s/%s sets neither podName nor selector", phs.GetNamespace(), phs.GetName())
+	}
+
+	selector, err := meta_v1.LabelSelectorAsSelector(phs.Spec.Selector)
+	if err != nil {
+		return nil, err
+	}
+
+	var pods core_v1.PodList
+	if err := r.List(ctx, &pods, client.InNamespace(phs.GetNamespace()), client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return nil, err
+	}
+
+	if len(pods.Items) == 0 {
+		return nil, nil
+	}
+
+	sort.Slice(pods.Items, func(i, j int) bool {
+		return pods.Items[i].GetName() < pods.Items[j].GetName()
+	})
+
+	return &pods.Items[0], nil
+}
+
+// matchesPod reports whether pod is selected by phs's podName or selector.
+// With a selector, every matching pod is reported, not only the one
+// matchedPod picks.
+func matchesPod(phs *srcdv1alpha1.PodHeadlessService, pod *core_v1.Pod) (bool, error) {
+	if phs.GetNamespace() != pod.GetNamespace() {
+		return false, nil
+	}
+
+	if phs.Spec.PodName != "" {
+		return phs.Spec.PodName == pod.GetName(), nil
+	}
+
+	if phs.Spec.Selector == nil {
+		return false, nil
+	}
+
+	selector, err := meta_v1.LabelSelectorAsSelector(phs.Spec.Selector)
+	if err != nil {
+		return false, err
+	}
+
+	return selector.Matches(labels.Set(pod.GetLabels())), nil
+}
+
+func (r *PodHeadlessServiceReconciler) reconcileService(ctx context.Context, phs *srcdv1alpha1.PodHeadlessService, pod *core_v1.Pod, ports []core_v1.ServicePort) error {
+	svc := &core_v1.Service{ObjectMeta: meta_v1.ObjectMeta{Name: phs.GetName(), Namespace: phs.GetNamespace()}}
+
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, svc, func() error {
+		svc.Labels = mergeStrings(phs.Spec.ServiceLabels, map[string]string{ManagedByLabel: ManagedByValue})
+		svc.Annotations = headlessservice.ServiceAnnotations(pod)
+		for k, v := range phs.Spec.ServiceAnnotations {
+			svc.Annotations[k] = v
+		}
+
+		svc.Spec.ClusterIP = "None" // headless service
+		svc.Spec.Ports = ports
+		svc.Spec.PublishNotReadyAddresses = phs.Spec.PublishNotReadyAddresses || headlessservice.PublishNotReadyAddresses(pod)
+
+		return controllerutil.SetControllerReference(phs, svc, r.Scheme)
+	})
+
+	return err
+}
+
+func (r *PodHeadlessServiceReconciler) reconcileEndpoints(ctx context.Context, phs *srcdv1alpha1.PodHeadlessService, pod *core_v1.Pod, ports []core_v1.ServicePort) error {
+	endpoints := &core_v1.Endpoints{ObjectMeta: meta_v1.ObjectMeta{Name: phs.GetName(), Namespace: phs.GetNamespace()}}
+
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, endpoints, func() error {
+		endpoints.Labels = map[string]string{ManagedByLabel: ManagedByValue}
+
+		subset := headlessservice.EndpointSubset(pod, ports)
+		if phs.Spec.PublishNotReadyAddresses {
+			subset.Addresses = append(subset.Addresses, subset.NotReadyAddresses...)
+			subset.NotReadyAddresses = nil
+		}
+		endpoints.Subsets = []core_v1.EndpointSubset{subset}
+
+		return controllerutil.SetControllerReference(phs, endpoints, r.Scheme)
+	})
+
+	return err
+}
+
+// clearEndpoints empties out phs's Endpoints subsets once its Pod stops
+// matching (deleted, rescheduled, relabeled), so clients resolving the
+// headless Service/SRV record stop being handed the dead pod's stale IP. The
+// Service itself is left in place so it starts resolving again as soon as
+// another Pod matches.
+func (r *PodHeadlessServiceReconciler) clearEndpoints(ctx context.Context, phs *srcdv1alpha1.PodHeadlessService) error {
+	var endpoints core_v1.Endpoints
+	key := types.NamespacedName{Namespace: phs.GetNamespace(), Name: phs.GetName()}
+	if err := r.Get(ctx, key, &endpoints); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+
+	if len(endpoints.Subsets) == 0 {
+		return nil
+	}
+
+	endpoints.Subsets = nil
+	return r.Update(ctx, &endpoints)
+}
+
+func (r *PodHeadlessServiceReconciler) updateStatus(ctx context.Context, phs *srcdv1alpha1.PodHeadlessService, pod *core_v1.Pod) error {
+	phs.Status.ObservedGeneration = phs.Generation
+
+	if pod == nil {
+		phs.Status.PodIP = ""
+		setCondition(phs, srcdv1alpha1.ConditionReady, core_v1.ConditionFalse, "NoMatchedPod", "no Pod matches the selector/podName yet")
+	} else {
+		phs.Status.PodIP = pod.Status.PodIP
+		setCondition(phs, srcdv1alpha1.ConditionServiceCreated, core_v1.ConditionTrue, "Reconciled", "Service created/updated")
+		setCondition(phs, srcdv1alpha1.ConditionEndpointsSynced, core_v1.ConditionTrue, "Reconciled", "Endpoints created/updated")
+		setCondition(phs, srcdv1alpha1.ConditionReady, core_v1.ConditionTrue, "Reconciled", "Service/Endpoints are in sync with the matched Pod")
+	}
+
+	return r.Status().Update(ctx, phs)
+}
+
+func setCondition(phs *srcdv1alpha1.PodHeadlessService, conditionType srcdv1alpha1.PodHeadlessServiceConditionType, status core_v1.ConditionStatus, reason, message string) {
+	for i, existing := range phs.Status.Conditions {
+		if existing.Type == conditionType {
+			if existing.Status != status {
+				phs.Status.Conditions[i].LastTransitionTime = meta_v1.Now()
+			}
+			phs.Status.Conditions[i].Status = status
+			phs.Status.Conditions[i].Reason = reason
+			phs.Status.Conditions[i].Message = message
+			return
+		}
+	}
+
+	phs.Status.Conditions = append(phs.Status.Conditions, srcdv1alpha1.PodHeadlessServiceCondition{
+		Type:               conditionType,
+		Status:             status,
+		LastTransitionTime: meta_v1.Now(),
+		Reason:             reason,
+		Message:            message,
+	})
+}
+
+func mergeStrings(maps ...map[string]string) map[string]string {
+	merged := map[string]string{}
+	for _, m := range maps {
+		for k, v := range m {
+			merged[k] = v
+		}
+	}
+
+	return merged
+}
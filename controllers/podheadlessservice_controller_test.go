@@ -0,0 +1,169 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	srcdv1alpha1 "github.com/src-d/k8s-pod-headless-service-operator/api/v1alpha1"
+)
+
+func newTestScheme(t *testing.T) *runtime.Scheme {
+	t.Helper()
+
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatalf("adding client-go types to scheme: %s", err)
+	}
+	if err := srcdv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatalf("adding srcd.host types to scheme: %s", err)
+	}
+
+	return scheme
+}
+
+func readyPod(namespace, name, ip string) *core_v1.Pod {
+	return &core_v1.Pod{
+		ObjectMeta: meta_v1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec: core_v1.PodSpec{
+			Containers: []core_v1.Container{{
+				Name:  "app",
+				Ports: []core_v1.ContainerPort{{ContainerPort: 8080}},
+			}},
+		},
+		Status: core_v1.PodStatus{
+			PodIP:      ip,
+			Conditions: []core_v1.PodCondition{{Type: core_v1.PodReady, Status: core_v1.ConditionTrue}},
+		},
+	}
+}
+
+func TestReconcileCreatesServiceAndEndpointsForMatchedPod(t *testing.T) {
+	scheme := newTestScheme(t)
+	pod := readyPod("default", "web-0", "10.0.0.1")
+	phs := &srcdv1alpha1.PodHeadlessService{
+		ObjectMeta: meta_v1.ObjectMeta{Namespace: "default", Name: "web"},
+		Spec:       srcdv1alpha1.PodHeadlessServiceSpec{PodName: "web-0"},
+	}
+
+	r := &PodHeadlessServiceReconciler{
+		Client: fake.NewFakeClientWithScheme(scheme, pod, phs),
+		Scheme: scheme,
+	}
+
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "web"}}
+	if _, err := r.Reconcile(req); err != nil {
+		t.Fatalf("Reconcile: %s", err)
+	}
+
+	ctx := context.Background()
+
+	var svc core_v1.Service
+	if err := r.Get(ctx, req.NamespacedName, &svc); err != nil {
+		t.Fatalf("getting service: %s", err)
+	}
+	if svc.Spec.ClusterIP != "None" {
+		t.Fatalf("expected a headless service, got ClusterIP %q", svc.Spec.ClusterIP)
+	}
+	if svc.Labels[ManagedByLabel] != ManagedByValue {
+		t.Fatalf("expected service to carry %s=%s, got %v", ManagedByLabel, ManagedByValue, svc.Labels)
+	}
+
+	var endpoints core_v1.Endpoints
+	if err := r.Get(ctx, req.NamespacedName, &endpoints); err != nil {
+		t.Fatalf("getting endpoints: %s", err)
+	}
+	if len(endpoints.Subsets) != 1 || len(endpoints.Subsets[0].Addresses) != 1 {
+		t.Fatalf("expected one ready address in endpoints, got %+v", endpoints.Subsets)
+	}
+	if endpoints.Subsets[0].Addresses[0].IP != "10.0.0.1" {
+		t.Fatalf("expected endpoint IP 10.0.0.1, got %q", endpoints.Subsets[0].Addresses[0].IP)
+	}
+
+	var updated srcdv1alpha1.PodHeadlessService
+	if err := r.Get(ctx, req.NamespacedName, &updated); err != nil {
+		t.Fatalf("getting PodHeadlessService: %s", err)
+	}
+	if updated.Status.PodIP != "10.0.0.1" {
+		t.Fatalf("expected status.podIP to be set, got %q", updated.Status.PodIP)
+	}
+}
+
+func TestReconcileClearsEndpointsWhenPodNoLongerMatches(t *testing.T) {
+	scheme := newTestScheme(t)
+	pod := readyPod("default", "web-0", "10.0.0.1")
+	phs := &srcdv1alpha1.PodHeadlessService{
+		ObjectMeta: meta_v1.ObjectMeta{Namespace: "default", Name: "web"},
+		Spec:       srcdv1alpha1.PodHeadlessServiceSpec{PodName: "web-0"},
+	}
+
+	r := &PodHeadlessServiceReconciler{
+		Client: fake.NewFakeClientWithScheme(scheme, pod, phs),
+		Scheme: scheme,
+	}
+
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "web"}}
+	ctx := context.Background()
+
+	if _, err := r.Reconcile(req); err != nil {
+		t.Fatalf("first Reconcile: %s", err)
+	}
+
+	if err := r.Delete(ctx, pod); err != nil {
+		t.Fatalf("deleting pod: %s", err)
+	}
+
+	if _, err := r.Reconcile(req); err != nil {
+		t.Fatalf("second Reconcile: %s", err)
+	}
+
+	var endpoints core_v1.Endpoints
+	if err := r.Get(ctx, req.NamespacedName, &endpoints); err != nil {
+		t.Fatalf("getting endpoints: %s", err)
+	}
+	if len(endpoints.Subsets) != 0 {
+		t.Fatalf("expected endpoints to be cleared once the pod is gone, got %+v", endpoints.Subsets)
+	}
+
+	var svc core_v1.Service
+	if err := r.Get(ctx, req.NamespacedName, &svc); err != nil {
+		t.Fatalf("expected the service to be left in place, got error: %s", err)
+	}
+}
+
+func TestReconcileMatchesPodBySelector(t *testing.T) {
+	scheme := newTestScheme(t)
+	pod := readyPod("default", "web-0", "10.0.0.2")
+	pod.Labels = map[string]string{"app": "web"}
+	phs := &srcdv1alpha1.PodHeadlessService{
+		ObjectMeta: meta_v1.ObjectMeta{Namespace: "default", Name: "web"},
+		Spec: srcdv1alpha1.PodHeadlessServiceSpec{
+			Selector: &meta_v1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+		},
+	}
+
+	r := &PodHeadlessServiceReconciler{
+		Client: fake.NewFakeClientWithScheme(scheme, pod, phs),
+		Scheme: scheme,
+	}
+
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "web"}}
+	if _, err := r.Reconcile(req); err != nil {
+		t.Fatalf("Reconcile: %s", err)
+	}
+
+	var endpoints core_v1.Endpoints
+	if err := r.Get(context.Background(), req.NamespacedName, &endpoints); err != nil {
+		t.Fatalf("getting endpoints: %s", err)
+	}
+	if len(endpoints.Subsets) != 1 || endpoints.Subsets[0].Addresses[0].IP != "10.0.0.2" {
+		t.Fatalf("expected endpoints to resolve the selector-matched pod, got %+v", endpoints.Subsets)
+	}
+}
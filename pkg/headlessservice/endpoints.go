@@ -0,0 +1,123 @@
+package headlessservice
+
+import (
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// PublishNotReadyAddressesAnnotation forces the pod IP into Endpoints'
+// Addresses (rather than NotReadyAddresses) even while the pod is not ready,
+// and makes the created Service tolerate unready endpoints. This is needed
+// for StatefulSet-style bootstrapping, where peers must discover each other
+// over DNS before any of them becomes ready.
+const PublishNotReadyAddressesAnnotation = "srcd.host/publish-not-ready-addresses"
+
+// TolerateUnreadyEndpointsAnnotation is the well-known annotation the
+// upstream endpoints controller honors to keep unready endpoints resolvable.
+const TolerateUnreadyEndpointsAnnotation = "service.alpha.kubernetes.io/tolerate-unready-endpoints"
+
+// PodTargetRef builds the TargetRef pointing at pod so that tooling such as
+// kube-state-metrics or external-dns can resolve an EndpointAddress back to
+// its owning pod.
+func PodTargetRef(pod *core_v1.Pod) *core_v1.ObjectReference {
+	return &core_v1.ObjectReference{
+		Kind:            "Pod",
+		Namespace:       pod.GetNamespace(),
+		Name:            pod.GetName(),
+		UID:             pod.GetUID(),
+		ResourceVersion: pod.GetResourceVersion(),
+	}
+}
+
+// PodOwnerReference builds an OwnerReference pointing at pod, with
+// BlockOwnerDeletion disabled since a headless Service shouldn't stop a pod
+// from being deleted. Setting it on the created Service/Endpoints lets the
+// Kubernetes garbage collector delete them once the pod is gone, instead of
+// relying on the informer's delete event being observed.
+func PodOwnerReference(pod *core_v1.Pod) meta_v1.OwnerReference {
+	controller := true
+	blockOwnerDeletion := false
+
+	return meta_v1.OwnerReference{
+		APIVersion:         "v1",
+		Kind:               "Pod",
+		Name:               pod.GetName(),
+		UID:                pod.GetUID(),
+		Controller:         &controller,
+		BlockOwnerDeletion: &blockOwnerDeletion,
+	}
+}
+
+// IsPodReady reports whether pod's PodReady condition is True and it isn't
+// being deleted, matching how the upstream endpoints controller decides
+// between Addresses and NotReadyAddresses.
+func IsPodReady(pod *core_v1.Pod) bool {
+	if pod.GetDeletionTimestamp() != nil {
+		return false
+	}
+
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == core_v1.PodReady {
+			return condition.Status == core_v1.ConditionTrue
+		}
+	}
+
+	return false
+}
+
+// PublishNotReadyAddresses reports whether pod opted into always publishing
+// its IP via PublishNotReadyAddressesAnnotation.
+func PublishNotReadyAddresses(pod *core_v1.Pod) bool {
+	return pod.Annotations[PublishNotReadyAddressesAnnotation] == "true"
+}
+
+// EndpointSubset builds the single EndpointSubset for pod, splitting its IP
+// between Addresses and NotReadyAddresses based on readiness.
+func EndpointSubset(pod *core_v1.Pod, ports []core_v1.ServicePort) core_v1.EndpointSubset {
+	address := core_v1.EndpointAddress{
+		IP:        pod.Status.PodIP,
+		TargetRef: PodTargetRef(pod),
+	}
+
+	subset := core_v1.EndpointSubset{Ports: EndpointPorts(ports)}
+	if PublishNotReadyAddresses(pod) || IsPodReady(pod) {
+		subset.Addresses = []core_v1.EndpointAddress{address}
+	} else {
+		subset.NotReadyAddresses = []core_v1.EndpointAddress{address}
+	}
+
+	return subset
+}
+
+// ServiceAnnotations copies pod's annotations onto the Service, adding
+// TolerateUnreadyEndpointsAnnotation when the pod asked to always publish its
+// address via PublishNotReadyAddressesAnnotation.
+func ServiceAnnotations(pod *core_v1.Pod) map[string]string {
+	annotations := make(map[string]string, len(pod.GetAnnotations())+1)
+	for k, v := range pod.GetAnnotations() {
+		annotations[k] = v
+	}
+
+	if PublishNotReadyAddresses(pod) {
+		annotations[TolerateUnreadyEndpointsAnnotation] = "true"
+	}
+
+	return annotations
+}
+
+// EndpointNeedsUpdate reports whether endpoint's existing subset disagrees
+// with the IP or readiness pod currently reports.
+func EndpointNeedsUpdate(endpoint *core_v1.Endpoints, pod *core_v1.Pod) bool {
+	if len(endpoint.Subsets) == 0 {
+		return true
+	}
+
+	subset := endpoint.Subsets[0]
+	wantReady := PublishNotReadyAddresses(pod) || IsPodReady(pod)
+
+	if wantReady {
+		return len(subset.Addresses) == 0 || subset.Addresses[0].IP != pod.Status.PodIP
+	}
+
+	return len(subset.NotReadyAddresses) == 0 || subset.NotReadyAddresses[0].IP != pod.Status.PodIP
+}
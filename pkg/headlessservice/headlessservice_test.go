@@ -0,0 +1,187 @@
+package headlessservice
+
+import (
+	"testing"
+
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func TestServicePortsFromContainers(t *testing.T) {
+	pod := &core_v1.Pod{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "web-0"},
+		Spec: core_v1.PodSpec{
+			Containers: []core_v1.Container{
+				{
+					Ports: []core_v1.ContainerPort{
+						{Name: "http", ContainerPort: 8080, Protocol: core_v1.ProtocolTCP},
+						{Name: "grpc", ContainerPort: 9090, Protocol: core_v1.ProtocolTCP},
+						{ContainerPort: 53, Protocol: core_v1.ProtocolUDP},
+					},
+				},
+			},
+		},
+	}
+
+	ports, err := ServicePorts(pod)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(ports) != 3 {
+		t.Fatalf("expected 3 ports, got %d", len(ports))
+	}
+
+	if ports[0].Name != "http" || ports[0].Port != 8080 || ports[0].TargetPort != intstr.FromString("http") {
+		t.Errorf("unexpected named port: %+v", ports[0])
+	}
+
+	if ports[1].Name != "grpc" || ports[1].Protocol != core_v1.ProtocolTCP {
+		t.Errorf("unexpected second port: %+v", ports[1])
+	}
+
+	if ports[2].Name != "udp-53" || ports[2].Protocol != core_v1.ProtocolUDP || ports[2].TargetPort != intstr.FromInt(53) {
+		t.Errorf("unexpected unnamed port: %+v", ports[2])
+	}
+}
+
+func TestServicePortsFromAnnotation(t *testing.T) {
+	pod := &core_v1.Pod{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Name: "web-0",
+			Annotations: map[string]string{
+				ServicePortsAnnotation: "grpc:9090/TCP,metrics:9100/TCP,dns:53/UDP",
+			},
+		},
+	}
+
+	ports, err := ServicePorts(pod)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(ports) != 3 {
+		t.Fatalf("expected 3 ports, got %d", len(ports))
+	}
+
+	if ports[0].Name != "grpc" || ports[0].Port != 9090 || ports[0].Protocol != core_v1.ProtocolTCP {
+		t.Errorf("unexpected port: %+v", ports[0])
+	}
+
+	if ports[2].Name != "dns" || ports[2].Port != 53 || ports[2].Protocol != core_v1.ProtocolUDP {
+		t.Errorf("unexpected port: %+v", ports[2])
+	}
+}
+
+func TestServicePortsAnnotationDefaultsToTCP(t *testing.T) {
+	pod := &core_v1.Pod{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Annotations: map[string]string{ServicePortsAnnotation: "http:8080"},
+		},
+	}
+
+	ports, err := ServicePorts(pod)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(ports) != 1 || ports[0].Protocol != core_v1.ProtocolTCP {
+		t.Fatalf("expected a single TCP port, got %+v", ports)
+	}
+}
+
+func TestServicePortsAnnotationInvalid(t *testing.T) {
+	pod := &core_v1.Pod{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Annotations: map[string]string{ServicePortsAnnotation: "not-a-valid-entry"},
+		},
+	}
+
+	if _, err := ServicePorts(pod); err == nil {
+		t.Fatal("expected an error for a malformed annotation")
+	}
+}
+
+func TestEndpointSubsetReadiness(t *testing.T) {
+	readyPod := &core_v1.Pod{
+		Status: core_v1.PodStatus{
+			PodIP: "10.0.0.1",
+			Conditions: []core_v1.PodCondition{
+				{Type: core_v1.PodReady, Status: core_v1.ConditionTrue},
+			},
+		},
+	}
+
+	subset := EndpointSubset(readyPod, nil)
+	if len(subset.Addresses) != 1 || len(subset.NotReadyAddresses) != 0 {
+		t.Fatalf("expected a ready pod's IP in Addresses, got %+v", subset)
+	}
+
+	notReadyPod := &core_v1.Pod{
+		Status: core_v1.PodStatus{
+			PodIP: "10.0.0.2",
+			Conditions: []core_v1.PodCondition{
+				{Type: core_v1.PodReady, Status: core_v1.ConditionFalse},
+			},
+		},
+	}
+
+	subset = EndpointSubset(notReadyPod, nil)
+	if len(subset.NotReadyAddresses) != 1 || len(subset.Addresses) != 0 {
+		t.Fatalf("expected a not-ready pod's IP in NotReadyAddresses, got %+v", subset)
+	}
+
+	notReadyPod.Annotations = map[string]string{PublishNotReadyAddressesAnnotation: "true"}
+	subset = EndpointSubset(notReadyPod, nil)
+	if len(subset.Addresses) != 1 || len(subset.NotReadyAddresses) != 0 {
+		t.Fatalf("expected %s to force the IP into Addresses, got %+v", PublishNotReadyAddressesAnnotation, subset)
+	}
+}
+
+func TestEndpointNeedsUpdate(t *testing.T) {
+	pod := &core_v1.Pod{
+		Status: core_v1.PodStatus{
+			PodIP: "10.0.0.1",
+			Conditions: []core_v1.PodCondition{
+				{Type: core_v1.PodReady, Status: core_v1.ConditionTrue},
+			},
+		},
+	}
+
+	if !EndpointNeedsUpdate(&core_v1.Endpoints{}, pod) {
+		t.Error("expected an update when there are no existing subsets")
+	}
+
+	current := &core_v1.Endpoints{
+		Subsets: []core_v1.EndpointSubset{EndpointSubset(pod, nil)},
+	}
+	if EndpointNeedsUpdate(current, pod) {
+		t.Error("did not expect an update when the existing subset already matches")
+	}
+
+	pod.Status.Conditions[0].Status = core_v1.ConditionFalse
+	if !EndpointNeedsUpdate(current, pod) {
+		t.Error("expected an update on a readiness transition even though the IP didn't change")
+	}
+}
+
+func TestEndpointPortsMirrorsServicePorts(t *testing.T) {
+	ports := []core_v1.ServicePort{
+		{Name: "http", Port: 8080, Protocol: core_v1.ProtocolTCP},
+		{Name: "dns", Port: 53, Protocol: core_v1.ProtocolUDP},
+	}
+
+	epPorts := EndpointPorts(ports)
+	if len(epPorts) != 2 {
+		t.Fatalf("expected 2 endpoint ports, got %d", len(epPorts))
+	}
+
+	if epPorts[0].Name != "http" || epPorts[0].Port != 8080 || epPorts[0].Protocol != core_v1.ProtocolTCP {
+		t.Errorf("unexpected endpoint port: %+v", epPorts[0])
+	}
+
+	if epPorts[1].Name != "dns" || epPorts[1].Port != 53 || epPorts[1].Protocol != core_v1.ProtocolUDP {
+		t.Errorf("unexpected endpoint port: %+v", epPorts[1])
+	}
+}
@@ -0,0 +1,113 @@
+// Package headlessservice holds the logic for deriving a headless
+// Service/Endpoints pair from a Pod. It is shared by the legacy
+// annotation-driven code path (cmd/k8s-pod-headless-service-operator) and the
+// PodHeadlessService reconciler (controllers).
+package headlessservice
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	core_v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// ServicePortsAnnotation is the annotation used to declare the ports to
+// expose on the Service/Endpoints when the pod's containers don't declare
+// any themselves, e.g. "grpc:9090/TCP,metrics:9100/TCP".
+const ServicePortsAnnotation = "srcd.host/service-ports"
+
+// ServicePorts derives the Service/Endpoints ports for pod. Ports declared on
+// the pod's containers take precedence; if none are declared (e.g. the
+// containers only expose ports implicitly) it falls back to the
+// ServicePortsAnnotation.
+func ServicePorts(pod *core_v1.Pod) ([]core_v1.ServicePort, error) {
+	var ports []core_v1.ServicePort
+	for _, container := range pod.Spec.Containers {
+		for _, containerPort := range container.Ports {
+			protocol := containerPort.Protocol
+			if protocol == "" {
+				protocol = core_v1.ProtocolTCP
+			}
+
+			name := containerPort.Name
+			targetPort := intstr.FromInt(int(containerPort.ContainerPort))
+			if name != "" {
+				targetPort = intstr.FromString(name)
+			} else {
+				name = fmt.Sprintf("%s-%d", strings.ToLower(string(protocol)), containerPort.ContainerPort)
+			}
+
+			ports = append(ports, core_v1.ServicePort{
+				Name:       name,
+				Protocol:   protocol,
+				Port:       containerPort.ContainerPort,
+				TargetPort: targetPort,
+			})
+		}
+	}
+
+	if len(ports) > 0 {
+		return ports, nil
+	}
+
+	return ParseServicePortsAnnotation(pod.Annotations[ServicePortsAnnotation])
+}
+
+// ParseServicePortsAnnotation parses a ServicePortsAnnotation value such as
+// "grpc:9090/TCP,metrics:9100/TCP" into ServicePorts. Protocol defaults to TCP
+// when omitted.
+func ParseServicePortsAnnotation(value string) ([]core_v1.ServicePort, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	var ports []core_v1.ServicePort
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		nameAndRest := strings.SplitN(entry, ":", 2)
+		if len(nameAndRest) != 2 {
+			return nil, fmt.Errorf("invalid %s entry %q, expected name:port[/protocol]", ServicePortsAnnotation, entry)
+		}
+
+		portAndProtocol := strings.SplitN(nameAndRest[1], "/", 2)
+		port, err := strconv.Atoi(portAndProtocol[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid port in %s entry %q: %s", ServicePortsAnnotation, entry, err)
+		}
+
+		protocol := core_v1.ProtocolTCP
+		if len(portAndProtocol) == 2 {
+			protocol = core_v1.Protocol(strings.ToUpper(portAndProtocol[1]))
+		}
+
+		ports = append(ports, core_v1.ServicePort{
+			Name:       nameAndRest[0],
+			Protocol:   protocol,
+			Port:       int32(port),
+			TargetPort: intstr.FromInt(port),
+		})
+	}
+
+	return ports, nil
+}
+
+// EndpointPorts mirrors ServicePorts onto the EndpointPort shape expected by
+// EndpointSubset.
+func EndpointPorts(ports []core_v1.ServicePort) []core_v1.EndpointPort {
+	epPorts := make([]core_v1.EndpointPort, 0, len(ports))
+	for _, port := range ports {
+		epPorts = append(epPorts, core_v1.EndpointPort{
+			Name:     port.Name,
+			Protocol: port.Protocol,
+			Port:     port.Port,
+		})
+	}
+
+	return epPorts
+}